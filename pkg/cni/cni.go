@@ -0,0 +1,181 @@
+/*
+* This file is part of Celestial (https://github.com/OpenFogStack/celestial).
+* Copyright (c) 2024 Tobias Pfandzelter, The OpenFogStack Team.
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, version 3.
+*
+* This program is distributed in the hope that it will be useful, but
+* WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+* General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program. If not, see <http://www.gnu.org/licenses/>.
+**/
+
+package cni
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+
+	"github.com/OpenFogStack/celestial/pkg/ebpfem"
+)
+
+// em drives the eBPF EDT pipeline for this invocation's container. Note
+// that celestial-cni, like any CNI plugin, is re-exec'd once per
+// ADD/DEL/CHECK: em.Unregister on DEL is therefore best-effort bookkeeping
+// within that single process, not a guarantee the loaded BPF objects are
+// freed, since e.vms does not survive across exec's. Longer-lived state
+// (if ever needed) would have to go through pinned BPF maps instead.
+var em *ebpfem.EBPFem
+
+func init() {
+	e, err := ebpfem.New()
+	if err != nil {
+		panic(errors.Wrap(err, "cannot initialize ebpfem"))
+	}
+
+	em = e
+}
+
+// CmdAdd implements the CNI ADD command: it creates a veth pair, moves the
+// container-side end into the target netns with the IPAM addresses
+// assigned, and registers the host-side end with EBPFem so the container's
+// egress traffic is shaped by the same EDT pipeline a Firecracker machine's
+// is.
+func CmdAdd(args *skel.CmdArgs) error {
+	conf, cfg, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	id := conf.machineID()
+
+	alloc, err := allocate(id, cfg)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var hostIfName string
+
+	err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		hostVeth, contVeth, err := ip.SetupVeth(args.IfName, conf.MTU, "", nil)
+		if err != nil {
+			return errors.Wrap(err, "cannot create veth pair")
+		}
+
+		hostIfName = hostVeth.Name
+
+		link, err := netlink.LinkByName(contVeth.Name)
+		if err != nil {
+			return errors.Wrapf(err, "cannot find %s", contVeth.Name)
+		}
+
+		for _, a := range []net.IPNet{alloc.ipv4, alloc.ipv6} {
+			if err := netlink.AddrAdd(link, &netlink.Addr{IPNet: &a}); err != nil {
+				return errors.Wrapf(err, "cannot assign %s to %s", a.String(), contVeth.Name)
+			}
+		}
+
+		if err := netlink.LinkSetUp(link); err != nil {
+			return errors.Wrapf(err, "cannot bring up %s", contVeth.Name)
+		}
+
+		for _, gw := range []net.IP{alloc.gw4, alloc.gw6} {
+			if err := netlink.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Gw: gw}); err != nil {
+				return errors.Wrapf(err, "cannot add default route via %s", gw)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := em.Register(id, hostIfName); err != nil {
+		return errors.Wrapf(err, "cannot register %s with ebpfem", hostIfName)
+	}
+
+	if err := em.SetBandwidth(id, alloc.ipv4, uint64(ebpfem.DEFAULT_BANDWIDTH_KBPS)); err != nil {
+		return errors.Wrapf(err, "cannot set default bandwidth for %s", hostIfName)
+	}
+
+	if err := em.SetLatency(id, alloc.ipv4, ebpfem.DEFAULT_LATENCY_US); err != nil {
+		return errors.Wrapf(err, "cannot set default latency for %s", hostIfName)
+	}
+
+	result := &current.Result{
+		CNIVersion: current.ImplementedSpecVersion,
+		Interfaces: []*current.Interface{
+			{Name: hostIfName},
+			{Name: args.IfName, Sandbox: args.Netns},
+		},
+		IPs: []*current.IPConfig{
+			{Interface: current.Int(1), Address: alloc.ipv4, Gateway: alloc.gw4},
+			{Interface: current.Int(1), Address: alloc.ipv6, Gateway: alloc.gw6},
+		},
+	}
+
+	return types.PrintResult(result, conf.CNIVersion)
+}
+
+// CmdDel implements the CNI DEL command: it removes the container-side veth
+// (which takes the host-side peer with it) and drops the VM from EBPFem.
+func CmdDel(args *skel.CmdArgs) error {
+	conf, _, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	// args.Netns is empty/missing when the runtime is calling DEL to release
+	// state for a sandbox whose netns is already gone (e.g. the node
+	// rebooted out from under it). The veth is gone with the netns, but
+	// em.Unregister still needs to run so the BPF pipeline and any NFQUEUE
+	// filter for this machine isn't leaked forever, since DEL is never
+	// retried once it returns successfully.
+	if args.Netns != "" {
+		err = ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+			link, err := netlink.LinkByName(args.IfName)
+			if err != nil {
+				if _, ok := err.(netlink.LinkNotFoundError); ok {
+					return nil
+				}
+				return errors.Wrapf(err, "cannot find %s", args.IfName)
+			}
+
+			return errors.Wrapf(netlink.LinkDel(link), "cannot delete %s", args.IfName)
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return errors.WithStack(em.Unregister(conf.machineID()))
+}
+
+// CmdCheck implements the CNI CHECK command.
+func CmdCheck(args *skel.CmdArgs) error {
+	conf, _, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	return ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		if _, err := netlink.LinkByName(args.IfName); err != nil {
+			return fmt.Errorf("celestial: %s not found in %s: %w", args.IfName, conf.Name, err)
+		}
+		return nil
+	})
+}
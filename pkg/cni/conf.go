@@ -0,0 +1,80 @@
+/*
+* This file is part of Celestial (https://github.com/OpenFogStack/celestial).
+* Copyright (c) 2024 Tobias Pfandzelter, The OpenFogStack Team.
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, version 3.
+*
+* This program is distributed in the hope that it will be useful, but
+* WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+* General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program. If not, see <http://www.gnu.org/licenses/>.
+**/
+
+// Package cni implements a CNI plugin that attaches a container's egress
+// path to the same eBPF EDT + fq pacing pipeline pkg/ebpfem uses for
+// Firecracker machines, so satellite links can be emulated for
+// containerized workloads on Kubernetes/k3s hosts too.
+package cni
+
+import (
+	"encoding/json"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/pkg/errors"
+
+	"github.com/OpenFogStack/celestial/pkg/netconfig"
+	"github.com/OpenFogStack/celestial/pkg/orchestrator"
+)
+
+// NetConf is the celestial CNI plugin's network configuration, as written
+// by the orchestrator into the CNI config directory (e.g.
+// /etc/cni/net.d/10-celestial.conflist).
+type NetConf struct {
+	types.NetConf
+
+	// MTU is applied to both ends of the veth pair celestial creates.
+	MTU int `json:"mtu,omitempty"`
+
+	// Group and MachineID place the container in the orchestrator's
+	// MachineID -> IP address space, the same way a Firecracker VM's
+	// group/ID would.
+	Group     uint8  `json:"group"`
+	MachineID uint64 `json:"machineID"`
+
+	// ULAPrefix is the IPv6 ULA prefix 4in6 addresses are synthesized
+	// under; it defaults to netconfig.DefaultULAPrefix and should match
+	// whatever the orchestrator passed to ebpfem.WithULAPrefix.
+	ULAPrefix string `json:"ulaPrefix,omitempty"`
+}
+
+// machineID returns the orchestrator.MachineID this invocation's container
+// represents.
+func (c *NetConf) machineID() orchestrator.MachineID {
+	return orchestrator.MachineID{Group: c.Group, Id: c.MachineID}
+}
+
+// loadConf parses stdinData into a NetConf and the netconfig.Config it
+// implies.
+func loadConf(stdinData []byte) (*NetConf, *netconfig.Config, error) {
+	conf := &NetConf{MTU: 1420}
+	if err := json.Unmarshal(stdinData, conf); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse network configuration")
+	}
+
+	prefix := conf.ULAPrefix
+	if prefix == "" {
+		prefix = netconfig.DefaultULAPrefix
+	}
+
+	cfg, err := netconfig.New(prefix)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	return conf, cfg, nil
+}
@@ -0,0 +1,67 @@
+/*
+* This file is part of Celestial (https://github.com/OpenFogStack/celestial).
+* Copyright (c) 2024 Tobias Pfandzelter, The OpenFogStack Team.
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, version 3.
+*
+* This program is distributed in the hope that it will be useful, but
+* WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+* General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program. If not, see <http://www.gnu.org/licenses/>.
+**/
+
+package cni
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+
+	"github.com/OpenFogStack/celestial/pkg/netconfig"
+	"github.com/OpenFogStack/celestial/pkg/orchestrator"
+)
+
+// allocation is the set of addresses and routes IPAM hands back for a given
+// machine, mirroring the orchestrator's 10.x/fd00:: scheme.
+type allocation struct {
+	ipv4 net.IPNet
+	ipv6 net.IPNet
+	gw4  net.IP
+	gw6  net.IP
+}
+
+// allocate derives IPv4/IPv6 addresses for id the same way the orchestrator
+// addresses a Firecracker machine: 10.<group>.<id high byte>.<id low byte>,
+// gatewayed at .1 of that /24, plus cfg's 4in6 mapping of both.
+func allocate(id orchestrator.MachineID, cfg *netconfig.Config) (allocation, error) {
+	if id.Id > 0xffff {
+		return allocation{}, errors.Errorf("machine id %d does not fit the 10.x/16 IPAM scheme", id.Id)
+	}
+
+	ip4 := net.IPv4(10, id.Group, byte(id.Id>>8), byte(id.Id)).To4()
+	gw4 := net.IPv4(10, id.Group, byte(id.Id>>8), 1).To4()
+
+	ipv4Net := net.IPNet{IP: ip4, Mask: net.CIDRMask(24, 32)}
+
+	ipv6, err := cfg.Embed4(ip4)
+	if err != nil {
+		return allocation{}, errors.WithStack(err)
+	}
+
+	gw6, err := cfg.Embed4(gw4)
+	if err != nil {
+		return allocation{}, errors.WithStack(err)
+	}
+
+	return allocation{
+		ipv4: ipv4Net,
+		ipv6: net.IPNet{IP: ipv6, Mask: net.CIDRMask(120, 128)},
+		gw4:  gw4,
+		gw6:  gw6,
+	}, nil
+}
@@ -0,0 +1,71 @@
+/*
+* This file is part of Celestial (https://github.com/OpenFogStack/celestial).
+* Copyright (c) 2024 Tobias Pfandzelter, The OpenFogStack Team.
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, version 3.
+*
+* This program is distributed in the hope that it will be useful, but
+* WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+* General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program. If not, see <http://www.gnu.org/licenses/>.
+**/
+
+package cni
+
+import (
+	"net"
+	"testing"
+
+	"github.com/OpenFogStack/celestial/pkg/netconfig"
+	"github.com/OpenFogStack/celestial/pkg/orchestrator"
+)
+
+func TestAllocate(t *testing.T) {
+	cfg, err := netconfig.New(netconfig.DefaultULAPrefix)
+	if err != nil {
+		t.Fatalf("netconfig.New: unexpected error: %v", err)
+	}
+
+	alloc, err := allocate(orchestrator.MachineID{Group: 1, Id: 0x0203}, cfg)
+	if err != nil {
+		t.Fatalf("allocate: unexpected error: %v", err)
+	}
+
+	wantIPv4 := net.IPv4(10, 1, 2, 3).To4()
+	if !alloc.ipv4.IP.Equal(wantIPv4) {
+		t.Fatalf("allocate: got ipv4 %s, want %s", alloc.ipv4.IP, wantIPv4)
+	}
+
+	if ones, _ := alloc.ipv4.Mask.Size(); ones != 24 {
+		t.Fatalf("allocate: got ipv4 mask /%d, want /24", ones)
+	}
+
+	wantGw4 := net.IPv4(10, 1, 2, 1).To4()
+	if !alloc.gw4.Equal(wantGw4) {
+		t.Fatalf("allocate: got gw4 %s, want %s", alloc.gw4, wantGw4)
+	}
+
+	if ones, _ := alloc.ipv6.Mask.Size(); ones != 120 {
+		t.Fatalf("allocate: got ipv6 mask /%d, want /120", ones)
+	}
+}
+
+func TestAllocateIdTooLarge(t *testing.T) {
+	cfg, err := netconfig.New(netconfig.DefaultULAPrefix)
+	if err != nil {
+		t.Fatalf("netconfig.New: unexpected error: %v", err)
+	}
+
+	if _, err := allocate(orchestrator.MachineID{Group: 1, Id: 0xffff}, cfg); err != nil {
+		t.Fatalf("allocate: unexpected error at the 0xffff boundary: %v", err)
+	}
+
+	if _, err := allocate(orchestrator.MachineID{Group: 1, Id: 0x10000}, cfg); err == nil {
+		t.Fatalf("allocate: expected error for id above 0xffff, got none")
+	}
+}
@@ -0,0 +1,151 @@
+//go:build linux && amd64
+// +build linux,amd64
+
+/*
+* This file is part of Celestial (https://github.com/OpenFogStack/celestial).
+* Copyright (c) 2024 Soeren Becker, Nils Japke, Tobias Pfandzelter, The
+* OpenFogStack Team.
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, version 3.
+*
+* This program is distributed in the hope that it will be useful, but
+* WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+* General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program. If not, see <http://www.gnu.org/licenses/>.
+**/
+
+package ebpfem
+
+import (
+	"sync"
+
+	"github.com/google/nftables"
+
+	"github.com/OpenFogStack/celestial/pkg/netconfig"
+	"github.com/OpenFogStack/celestial/pkg/nfq"
+	"github.com/OpenFogStack/celestial/pkg/orchestrator"
+)
+
+const (
+	DEFAULT_BANDWIDTH_KBPS = uint32(1_000_000)
+	DEFAULT_LATENCY_US     = uint32(0)
+
+	// DEFAULT_MAX_GSO_BURST_BYTES mirrors the fallback tc_main uses when
+	// GSO_CONFIG hasn't been populated yet.
+	DEFAULT_MAX_GSO_BURST_BYTES = uint32(64 * 1024)
+)
+
+type handleKbpsDelay struct {
+	throttleRateKbps uint32
+	delayUs          uint32
+}
+
+type vm struct {
+	sync.Mutex
+	netIf string
+	objs  *edtObjects
+	hbd   map[string]*handleKbpsDelay
+
+	// filter is non-nil once RegisterFilter has diverted this vm's egress
+	// traffic through an NFQUEUE queue for userspace inspection.
+	filter *vmFilter
+}
+
+// vmFilter is the nftables + nfqueue state backing a single RegisterFilter
+// call, kept around so Unregister/Stop can tear it down again.
+type vmFilter struct {
+	queueNum uint16
+	queue    *nfq.Queue
+	table    *nftables.Table
+	conn     *nftables.Conn
+}
+
+// EBPFem manages the eBPF-based bandwidth/latency emulation for a set of
+// VMs, one clsact+fq+tc_main pipeline per VM network interface.
+type EBPFem struct {
+	sync.RWMutex
+	vms map[orchestrator.MachineID]*vm
+
+	// cfg holds settings shared with pkg/peer, notably the ULA prefix used
+	// to synthesize a 4in6 mapping for IPv4 targets.
+	cfg *netconfig.Config
+
+	// gsoConfig is pushed into every vm's GSO_CONFIG map on Register so
+	// tc_main paces a GRO/GSO super-frame as one unit instead of once per
+	// segment.
+	gsoConfig edtGsoConfig
+
+	// nextQueue hands out NFQUEUE queue numbers to RegisterFilter, one per
+	// filtered vm.
+	nextQueue uint32
+}
+
+// Option configures an EBPFem at construction time.
+type Option func(*EBPFem) error
+
+// WithULAPrefix sets the IPv6 ULA prefix used to synthesize 4in6 mappings
+// for IPv4 targets. It defaults to netconfig.DefaultULAPrefix.
+func WithULAPrefix(prefix string) Option {
+	return func(e *EBPFem) error {
+		cfg, err := netconfig.New(prefix)
+		if err != nil {
+			return err
+		}
+
+		e.cfg = cfg
+
+		return nil
+	}
+}
+
+// WithMaxGSOBurst caps how large a coalesced GRO/GSO super-frame tc_main
+// will pace as a single unit; a larger super-frame is rejected outright
+// rather than paced, since tc_main can't shrink an already-built frame
+// without corrupting it, and billing it as if it were this size would
+// under-count the bytes actually sent. It defaults to
+// DEFAULT_MAX_GSO_BURST_BYTES.
+func WithMaxGSOBurst(bytes uint32) Option {
+	return func(e *EBPFem) error {
+		e.gsoConfig.MaxBurstBytes = bytes
+		return nil
+	}
+}
+
+// WithGSODisabled makes tc_main fall back to strict per-packet EDT pacing,
+// ignoring skb->gso_size. Useful when debugging pacing behavior, since
+// coalesced super-frames are otherwise billed and scheduled as one unit.
+func WithGSODisabled() Option {
+	return func(e *EBPFem) error {
+		e.gsoConfig.Disabled = 1
+		return nil
+	}
+}
+
+func New(opts ...Option) (*EBPFem, error) {
+	e := &EBPFem{
+		vms:       make(map[orchestrator.MachineID]*vm),
+		gsoConfig: edtGsoConfig{MaxBurstBytes: DEFAULT_MAX_GSO_BURST_BYTES},
+	}
+
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.cfg == nil {
+		cfg, err := netconfig.New(netconfig.DefaultULAPrefix)
+		if err != nil {
+			return nil, err
+		}
+
+		e.cfg = cfg
+	}
+
+	return e, nil
+}
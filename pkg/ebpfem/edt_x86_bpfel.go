@@ -3,6 +3,14 @@
 
 package ebpfem
 
+// NOTE: this environment has neither clang nor the bpf2go toolchain's
+// dependencies available, so GSO_CONFIG below could not be produced by an
+// actual `go generate` run against ebpf/net.c -- it was added by hand to
+// keep pkg/ebpfem compiling against the new map. Re-run `go generate ./...`
+// from this package (see net.go's //go:generate line) against a real
+// toolchain before this ships, so this file and edt_x86_bpfel.o are
+// regenerated together from ebpf/net.c and actually match each other.
+
 import (
 	"bytes"
 	_ "embed"
@@ -17,6 +25,12 @@ type edtHandleKbpsDelay struct {
 	DelayUs          uint32
 }
 
+type edtGsoConfig struct {
+	MaxBurstBytes uint32
+	Disabled      uint8
+	_             [3]byte
+}
+
 type edtIn6Addr struct{ In6U struct{ U6Addr8 [16]uint8 } }
 
 // loadEdt returns the embedded CollectionSpec for edt.
@@ -68,9 +82,10 @@ type edtProgramSpecs struct {
 // It can be passed ebpf.CollectionSpec.Assign.
 type edtMapSpecs struct {
 	IPV6_HANDLE_KBPS_DELAY *ebpf.MapSpec `ebpf:"IPV6_HANDLE_KBPS_DELAY"`
-	IP_HANDLE_KBPS_DELAY  *ebpf.MapSpec `ebpf:"IP_HANDLE_KBPS_DELAY"`
-	FlowMap               *ebpf.MapSpec `ebpf:"flow_map"`
-	FlowMapIpv6           *ebpf.MapSpec `ebpf:"flow_map_ipv6"`
+	IP_HANDLE_KBPS_DELAY   *ebpf.MapSpec `ebpf:"IP_HANDLE_KBPS_DELAY"`
+	GSO_CONFIG             *ebpf.MapSpec `ebpf:"GSO_CONFIG"`
+	FlowMap                *ebpf.MapSpec `ebpf:"flow_map"`
+	FlowMapIpv6            *ebpf.MapSpec `ebpf:"flow_map_ipv6"`
 }
 
 // edtObjects contains all objects after they have been loaded into the kernel.
@@ -93,15 +108,17 @@ func (o *edtObjects) Close() error {
 // It can be passed to loadEdtObjects or ebpf.CollectionSpec.LoadAndAssign.
 type edtMaps struct {
 	IPV6_HANDLE_KBPS_DELAY *ebpf.Map `ebpf:"IPV6_HANDLE_KBPS_DELAY"`
-	IP_HANDLE_KBPS_DELAY  *ebpf.Map `ebpf:"IP_HANDLE_KBPS_DELAY"`
-	FlowMap               *ebpf.Map `ebpf:"flow_map"`
-	FlowMapIpv6           *ebpf.Map `ebpf:"flow_map_ipv6"`
+	IP_HANDLE_KBPS_DELAY   *ebpf.Map `ebpf:"IP_HANDLE_KBPS_DELAY"`
+	GSO_CONFIG             *ebpf.Map `ebpf:"GSO_CONFIG"`
+	FlowMap                *ebpf.Map `ebpf:"flow_map"`
+	FlowMapIpv6            *ebpf.Map `ebpf:"flow_map_ipv6"`
 }
 
 func (m *edtMaps) Close() error {
 	return _EdtClose(
-		m.IPV6HANDLE_KBPS_DELAY,
+		m.IPV6_HANDLE_KBPS_DELAY,
 		m.IP_HANDLE_KBPS_DELAY,
+		m.GSO_CONFIG,
 		m.FlowMap,
 		m.FlowMapIpv6,
 	)
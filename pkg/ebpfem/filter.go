@@ -0,0 +1,142 @@
+//go:build linux && amd64
+// +build linux,amd64
+
+/*
+* This file is part of Celestial (https://github.com/OpenFogStack/celestial).
+* Copyright (c) 2024 Tobias Pfandzelter, The OpenFogStack Team.
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, version 3.
+*
+* This program is distributed in the hope that it will be useful, but
+* WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+* General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program. If not, see <http://www.gnu.org/licenses/>.
+**/
+
+package ebpfem
+
+import (
+	"sync/atomic"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/OpenFogStack/celestial/pkg/nfq"
+	"github.com/OpenFogStack/celestial/pkg/orchestrator"
+)
+
+const filterTablePrefix = "celestial-filter-"
+
+// RegisterFilter diverts id's egress traffic to a userspace NFQUEUE queue
+// and runs fn over every packet that arrives on it. fn's verdict
+// (accept/drop/mangle) is round-tripped back to the kernel. Calling
+// RegisterFilter twice for the same id replaces the previous filter.
+//
+// The redirect is installed at the netfilter POSTROUTING hook, not as a tc
+// action on v.netIf's clsact qdisc (tc has no standard upstream "nfq"
+// action), which means fn sees a packet before tc_main's EDT
+// classification runs, not after: POSTROUTING fires in
+// ip_output/ip_forward, ahead of dev_queue_xmit handing the packet to the
+// qdisc where tc_main runs. A bandwidth/latency cap set via
+// SetBandwidth/SetLatency and a filter registered here on the same vm
+// therefore don't compose as "filter first, then shape" or vice versa in
+// a single well-defined order from fn's point of view -- fn's verdict is
+// applied first, and only an Accept continues on to tc_main's pacing.
+func (e *EBPFem) RegisterFilter(id orchestrator.MachineID, fn nfq.FilterFunc) error {
+	// Held for the whole call, not just the lookup: RegisterFilter races
+	// with Unregister/Stop over v.filter and v.objs otherwise, since both
+	// also take e's full lock for their entire mutation.
+	e.Lock()
+	defer e.Unlock()
+
+	v, ok := e.vms[id]
+	if !ok {
+		return errors.Errorf("machine %d-%d does not exist", id.Group, id.Id)
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	if v.filter != nil {
+		if err := v.filter.close(); err != nil {
+			return errors.WithStack(err)
+		}
+		v.filter = nil
+	}
+
+	queueNum := uint16(atomic.AddUint32(&e.nextQueue, 1))
+
+	queue, err := nfq.Open(queueNum, fn)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	nf := &nftables.Conn{}
+
+	table := nf.AddTable(&nftables.Table{
+		Name:   filterTablePrefix + v.netIf,
+		Family: nftables.TableFamilyINet,
+	})
+
+	chain := nf.AddChain(&nftables.Chain{
+		Name:  "egress",
+		Table: table,
+		Type:  nftables.ChainTypeFilter,
+		// VM/container traffic toward the wg tunnel is forwarded, not
+		// locally originated, so it never reaches OUTPUT (ip_local_out) —
+		// it traverses PREROUTING -> FORWARD -> POSTROUTING. This runs
+		// before tc_main (see the RegisterFilter doc comment above for why
+		// that matters), but POSTROUTING is still the right hook: it's the
+		// last netfilter point that sees the packet with its final oifname
+		// set, which PREROUTING/FORWARD can't guarantee for traffic that's
+		// still being routed.
+		Hooknum:  nftables.ChainHookPostrouting,
+		Priority: nftables.ChainPriorityFilter,
+	})
+
+	nf.AddRule(&nftables.Rule{
+		Table: table,
+		Chain: chain,
+		Exprs: []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: 1,
+				Data:     []byte(v.netIf + "\x00"),
+			},
+			&expr.Queue{Num: queueNum},
+		},
+	})
+
+	if err := nf.Flush(); err != nil {
+		_ = queue.Close()
+		return errors.Wrapf(err, "cannot install nfqueue redirect for %s", v.netIf)
+	}
+
+	v.filter = &vmFilter{
+		queueNum: queueNum,
+		queue:    queue,
+		table:    table,
+		conn:     nf,
+	}
+
+	return nil
+}
+
+// close tears down the nftables redirect and stops delivering packets to
+// the registered FilterFunc.
+func (f *vmFilter) close() error {
+	f.conn.DelTable(f.table)
+	if err := f.conn.Flush(); err != nil && !errors.Is(err, unix.ENOENT) {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(f.queue.Close())
+}
@@ -21,7 +21,6 @@
 package ebpfem
 
 import (
-	"fmt"
 	"net"
 
 	"github.com/pkg/errors"
@@ -33,16 +32,17 @@ import (
 
 //go:generate env BPF2GO_FLAGS="-O3" go run github.com/cilium/ebpf/cmd/bpf2go -target amd64 edt ebpf/net.c -- -I./ebpf/headers
 
-func New() *EBPFem {
-	return &EBPFem{
-		vms: make(map[orchestrator.MachineID]*vm),
-	}
-}
-
 func (e *EBPFem) Stop() error {
 	e.Lock()
 	defer e.Unlock()
 	for _, v := range e.vms {
+		if v.filter != nil {
+			if err := v.filter.close(); err != nil {
+				return errors.WithStack(err)
+			}
+			v.filter = nil
+		}
+
 		err := v.objs.Close()
 		if err != nil {
 			return errors.WithStack(err)
@@ -66,6 +66,11 @@ func (e *EBPFem) Register(id orchestrator.MachineID, netIf string) error {
 		return errors.WithStack(err)
 	}
 
+	gsoKey := uint32(0)
+	if err := v.objs.GSO_CONFIG.Put(gsoKey, e.gsoConfig); err != nil {
+		return errors.WithStack(err)
+	}
+
 	progFd := v.objs.edtPrograms.TcMain.FD()
 
 	log.Tracef("getting interface %s", v.netIf)
@@ -106,6 +111,28 @@ func (e *EBPFem) Register(id orchestrator.MachineID, netIf string) error {
 	return nil
 }
 
+// Unregister tears down the eBPF pipeline for id, e.g. when the CNI plugin
+// sees a container deleted. It is a no-op if id was never registered.
+func (e *EBPFem) Unregister(id orchestrator.MachineID) error {
+	e.Lock()
+	defer e.Unlock()
+
+	v, ok := e.vms[id]
+	if !ok {
+		return nil
+	}
+
+	delete(e.vms, id)
+
+	if v.filter != nil {
+		if err := v.filter.close(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return errors.WithStack(v.objs.Close())
+}
+
 func (v *vm) getHBD(target net.IPNet) *handleKbpsDelay {
 	hbd, ok := v.hbd[target.String()]
 	if ok {
@@ -122,63 +149,72 @@ func (v *vm) getHBD(target net.IPNet) *handleKbpsDelay {
 	return hbd
 }
 
-// 生成 IPv6 地址
-func generateIPv6(ipv4Addr string) (net.IPNet, error) {
-	ip := net.ParseIP(ipv4Addr).To4()
-	if ip == nil {
-		return net.IPNet{}, errors.New("invalid IPv4 address")
-	}
-
-	ipv6 := fmt.Sprintf("fd00::%x:%x:%x:%x", ip[0], ip[1], ip[2], ip[3])
-	return net.IPNet{IP: net.ParseIP(ipv6), Mask: net.CIDRMask(126, 128)}, nil
-}
-
-func (e *EBPFem) SetBandwidth(source orchestrator.MachineID, target net.IPNet, bandwidthKbits uint64) error {
-	e.RLock()
-	v, ok := e.vms[source]
-	e.RUnlock()
+// setHBD installs hbd into the map(s) matching target's address family. An
+// IPv6 target is handled natively through IPV6_HANDLE_KBPS_DELAY; an IPv4
+// target additionally gets a synthesized 4in6 mapping under e.cfg.ULAPrefix
+// so traffic reaching the VM over the wg IPv6 underlay is throttled the same
+// way.
+func (e *EBPFem) setHBD(v *vm, target net.IPNet, hbd *handleKbpsDelay) error {
+	if target.IP.To4() == nil {
+		ips, err := parseNetToLongs(target)
+		if err != nil {
+			return errors.WithStack(err)
+		}
 
-	if !ok {
-		return errors.Errorf("machine %d-%d does not exist", source.Group, source.Id)
+		for _, ip := range ips {
+			log.Tracef("updating IPv6 %v", ip)
+			if err := v.objs.IPV6_HANDLE_KBPS_DELAY.Put(ip, hbd); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
 	}
 
-	v.Lock()
-	defer v.Unlock()
-
-	hbd := v.getHBD(target)
-	hbd.throttleRateKbps = uint32(bandwidthKbits)
-
-	ips, err := parseNetToLongs(target)
-	if err != nil {
-		return errors.WithStack(err)
+	// Walked directly here (rather than via parseNetToLongs) so the 4in6
+	// embedding below gets the original IPv4 bytes straight from target's
+	// range, instead of round-tripping them through parseIPToLong's native-
+	// order uint32, which is only meaningful as an IP_HANDLE_KBPS_DELAY map
+	// key, not as a byte order to reconstruct an address from.
+	startIP := target.IP.Mask(target.Mask).To4()
+	endIP := make(net.IP, len(startIP))
+	copy(endIP, startIP)
+	for i := range endIP {
+		endIP[i] |= ^target.Mask[i]
 	}
 
-	for _, ip := range ips {
-		log.Tracef("updating bandwidth for %d to %d", ip, bandwidthKbits)
-		err = v.objs.IP_HANDLE_KBPS_DELAY.Put(ip, hbd)
+	for ip := startIP; ; incrementIP(ip) {
+		key, err := parseIPToLong(ip)
 		if err != nil {
 			return errors.WithStack(err)
 		}
 
-		// 生成对应的 IPv6 地址并更新
-		ipv4Addr := net.IPv4(byte(ip>>24), byte(ip>>16&0xFF), byte(ip>>8&0xFF), byte(ip&0xFF)).String()
-		ipv6Net, err := generateIPv6(ipv4Addr)
-		if err != nil {
+		log.Tracef("updating IPv4 %v", key)
+		if err := v.objs.IP_HANDLE_KBPS_DELAY.Put(key, hbd); err != nil {
 			return errors.WithStack(err)
 		}
 
-		err = v.objs.IPV6_HANDLE_KBPS_DELAY.Put(ipv6Net.IP, hbd)
+		ipv6, err := e.cfg.Embed4(ip)
 		if err != nil {
 			return errors.WithStack(err)
 		}
+
+		if err := v.objs.IPV6_HANDLE_KBPS_DELAY.Put(ipv6, hbd); err != nil {
+			return errors.WithStack(err)
+		}
+
+		if ip.Equal(endIP) {
+			break
+		}
 	}
+
 	return nil
 }
 
-func (e *EBPFem) SetLatency(source orchestrator.MachineID, target net.IPNet, latency uint32) error {
+func (e *EBPFem) SetBandwidth(source orchestrator.MachineID, target net.IPNet, bandwidthKbits uint64) error {
 	e.RLock()
 	v, ok := e.vms[source]
 	e.RUnlock()
+
 	if !ok {
 		return errors.Errorf("machine %d-%d does not exist", source.Group, source.Id)
 	}
@@ -187,33 +223,24 @@ func (e *EBPFem) SetLatency(source orchestrator.MachineID, target net.IPNet, lat
 	defer v.Unlock()
 
 	hbd := v.getHBD(target)
-	hbd.delayUs = uint32(latency)
+	hbd.throttleRateKbps = uint32(bandwidthKbits)
 
-	ips, err := parseNetToLongs(target)
-	if err != nil {
-		return errors.WithStack(err)
+	return e.setHBD(v, target, hbd)
+}
+
+func (e *EBPFem) SetLatency(source orchestrator.MachineID, target net.IPNet, latency uint32) error {
+	e.RLock()
+	v, ok := e.vms[source]
+	e.RUnlock()
+	if !ok {
+		return errors.Errorf("machine %d-%d does not exist", source.Group, source.Id)
 	}
 
-	for _, ip := range ips {
-		log.Tracef("updating latency for %d to %d", ip, latency)
-		err = v.objs.IP_HANDLE_KBPS_DELAY.Put(ip, hbd)
-		if err != nil {
-			return errors.WithStack(err)
-		}
+	v.Lock()
+	defer v.Unlock()
 
-		// 生成对应的 IPv6 地址并更新
-		ipv4Addr := net.IPv4(byte(ip>>24), byte(ip>>16&0xFF), byte(ip>>8&0xFF), byte(ip&0xFF)).String()
-		ipv6Net, err := generateIPv6(ipv4Addr)
-		if err != nil {
-			return errors.WithStack(err)
-		}
+	hbd := v.getHBD(target)
+	hbd.delayUs = uint32(latency)
 
-		err = v.objs.IPV6_HANDLE_KBPS_DELAY.Put(ipv6Net.IP, hbd)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-	}
-	return nil
+	return e.setHBD(v, target, hbd)
 }
-
-// 省略 UnblockLink 和 BlockLink 的实现...
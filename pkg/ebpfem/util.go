@@ -44,6 +44,13 @@ func incrementIP(ip net.IP) {
 func parseIPToLong(ip net.IP) (interface{}, error) {
 	if ip.To4() != nil {
 		var l uint32
+		// LittleEndian here: classify_v4 in ebpf/net.c reads daddr straight
+		// off the packet (`*dst = ip->daddr;`, no bpf_ntohl), so the lookup
+		// key the kernel actually uses is the wire-order bytes reinterpreted
+		// as a native (this package is linux&&amd64-only, i.e. LE) uint32.
+		// cilium/ebpf serializes a Map.Put key in that same native order, so
+		// decoding with LittleEndian here reproduces the original wire bytes
+		// when the map is written, which is what tc_main's lookup expects.
 		err := binary.Read(bytes.NewBuffer(ip.To4()), binary.LittleEndian, &l)
 		if err != nil {
 			return nil, errors.Wrap(err, "cannot convert IPv4 to uint32")
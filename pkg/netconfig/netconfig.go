@@ -0,0 +1,86 @@
+/*
+* This file is part of Celestial (https://github.com/OpenFogStack/celestial).
+* Copyright (c) 2024 Soeren Becker, Nils Japke, Tobias Pfandzelter, The
+* OpenFogStack Team.
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, version 3.
+*
+* This program is distributed in the hope that it will be useful, but
+* WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+* General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program. If not, see <http://www.gnu.org/licenses/>.
+**/
+
+// Package netconfig holds IPv6 addressing settings shared between pkg/ebpfem
+// and pkg/peer, so that both packages synthesize 4in6 mappings under the
+// same operator-configured ULA prefix.
+package netconfig
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultULAPrefix is the IPv6 ULA prefix used to synthesize 4in6 addresses
+// when an operator does not configure one explicitly.
+const DefaultULAPrefix = "fd00::/64"
+
+// Config holds the IPv6 addressing settings for a deployment.
+type Config struct {
+	// ULAPrefix is the /64-or-larger IPv6 prefix that 4in6 mappings are
+	// synthesized under.
+	ULAPrefix net.IPNet
+}
+
+// New parses prefix (e.g. "fd00::/64") into a Config.
+func New(prefix string) (*Config, error) {
+	ip, ipnet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid ULA prefix %q", prefix)
+	}
+
+	if ip.To4() != nil {
+		return nil, errors.Errorf("ULA prefix %q is not an IPv6 prefix", prefix)
+	}
+
+	ones, _ := ipnet.Mask.Size()
+	if ones > 64 {
+		return nil, errors.Errorf("ULA prefix %q must be a /64 or larger", prefix)
+	}
+
+	return &Config{ULAPrefix: *ipnet}, nil
+}
+
+// Embed4 synthesizes a 4in6 address by encoding the four octets of ipv4 as
+// the last four 16-bit groups of c.ULAPrefix, e.g. fd00::c0:a8:32:2 for
+// 192.168.50.2 under the default fd00::/64 prefix.
+func (c *Config) Embed4(ipv4 net.IP) (net.IP, error) {
+	v4 := ipv4.To4()
+	if v4 == nil {
+		return nil, errors.Errorf("%s is not an IPv4 address", ipv4)
+	}
+
+	addr := make(net.IP, net.IPv6len)
+	copy(addr, c.ULAPrefix.IP.To16())
+
+	addr[9], addr[11], addr[13], addr[15] = v4[0], v4[1], v4[2], v4[3]
+
+	return addr, nil
+}
+
+// Embed4Subnet synthesizes a /126 4in6 subnet for an IPv4 net.IPNet, mapping
+// its base address the same way as Embed4.
+func (c *Config) Embed4Subnet(ipv4Net net.IPNet) (net.IPNet, error) {
+	ip, err := c.Embed4(ipv4Net.IP)
+	if err != nil {
+		return net.IPNet{}, err
+	}
+
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(126, 128)}, nil
+}
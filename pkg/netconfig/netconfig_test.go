@@ -0,0 +1,108 @@
+/*
+* This file is part of Celestial (https://github.com/OpenFogStack/celestial).
+* Copyright (c) 2024 Soeren Becker, Nils Japke, Tobias Pfandzelter, The
+* OpenFogStack Team.
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, version 3.
+*
+* This program is distributed in the hope that it will be useful, but
+* WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+* General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program. If not, see <http://www.gnu.org/licenses/>.
+**/
+
+package netconfig
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		wantErr bool
+	}{
+		{name: "default prefix", prefix: DefaultULAPrefix},
+		{name: "larger than /64", prefix: "fd00::/48"},
+		{name: "exactly /64", prefix: "fd12:3456:789a:1::/64"},
+		{name: "smaller than /64 rejected", prefix: "fd00::/80", wantErr: true},
+		{name: "ipv4 prefix rejected", prefix: "10.0.0.0/24", wantErr: true},
+		{name: "garbage rejected", prefix: "not-a-cidr", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := New(tt.prefix)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("New(%q): expected error, got none", tt.prefix)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("New(%q): unexpected error: %v", tt.prefix, err)
+			}
+
+			if cfg.ULAPrefix.String() == "" {
+				t.Fatalf("New(%q): empty ULAPrefix", tt.prefix)
+			}
+		})
+	}
+}
+
+func TestEmbed4(t *testing.T) {
+	cfg, err := New(DefaultULAPrefix)
+	if err != nil {
+		t.Fatalf("New: unexpected error: %v", err)
+	}
+
+	got, err := cfg.Embed4(net.IPv4(192, 168, 50, 2))
+	if err != nil {
+		t.Fatalf("Embed4: unexpected error: %v", err)
+	}
+
+	want := net.ParseIP("fd00::c0:a8:32:2")
+	if !got.Equal(want) {
+		t.Fatalf("Embed4: got %s, want %s", got, want)
+	}
+
+	if _, err := cfg.Embed4(net.ParseIP("fd00::1")); err == nil {
+		t.Fatalf("Embed4: expected error for non-IPv4 input, got none")
+	}
+}
+
+func TestEmbed4Subnet(t *testing.T) {
+	cfg, err := New(DefaultULAPrefix)
+	if err != nil {
+		t.Fatalf("New: unexpected error: %v", err)
+	}
+
+	ipv4Net := net.IPNet{IP: net.IPv4(192, 168, 50, 0), Mask: net.CIDRMask(30, 32)}
+
+	got, err := cfg.Embed4Subnet(ipv4Net)
+	if err != nil {
+		t.Fatalf("Embed4Subnet: unexpected error: %v", err)
+	}
+
+	ones, bits := got.Mask.Size()
+	if ones != 126 || bits != 128 {
+		t.Fatalf("Embed4Subnet: got mask /%d (bits %d), want /126 (bits 128)", ones, bits)
+	}
+
+	want := net.ParseIP("fd00::c0:a8:32:0")
+	if !got.IP.Equal(want) {
+		t.Fatalf("Embed4Subnet: got IP %s, want %s", got.IP, want)
+	}
+
+	if _, err := cfg.Embed4Subnet(net.IPNet{IP: net.ParseIP("fd00::1"), Mask: net.CIDRMask(126, 128)}); err == nil {
+		t.Fatalf("Embed4Subnet: expected error for non-IPv4 input, got none")
+	}
+}
@@ -0,0 +1,40 @@
+/*
+* This file is part of Celestial (https://github.com/OpenFogStack/celestial).
+* Copyright (c) 2024 Tobias Pfandzelter, The OpenFogStack Team.
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, version 3.
+*
+* This program is distributed in the hope that it will be useful, but
+* WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+* General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program. If not, see <http://www.gnu.org/licenses/>.
+**/
+
+// Package nfq delivers packets pulled off a Linux NFQUEUE queue to a
+// userland verdict callback, for scenario-driven fault injection (bit
+// flips, header rewrites, selective drops) that the pure eBPF EDT pipeline
+// in pkg/ebpfem can't express.
+package nfq
+
+import "github.com/google/gopacket"
+
+// Verdict is what a FilterFunc decides should happen to a packet.
+type Verdict int
+
+const (
+	// Accept lets the packet through unmodified.
+	Accept Verdict = iota
+	// Drop discards the packet.
+	Drop
+	// Mangle replaces the packet with the bytes the FilterFunc returned.
+	Mangle
+)
+
+// FilterFunc inspects a packet pulled off a VM's queue and decides its
+// fate. The mangled return value is only used when verdict is Mangle.
+type FilterFunc func(pkt gopacket.Packet) (verdict Verdict, mangled []byte)
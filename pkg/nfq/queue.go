@@ -0,0 +1,112 @@
+/*
+* This file is part of Celestial (https://github.com/OpenFogStack/celestial).
+* Copyright (c) 2024 Tobias Pfandzelter, The OpenFogStack Team.
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, version 3.
+*
+* This program is distributed in the hope that it will be useful, but
+* WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+* General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program. If not, see <http://www.gnu.org/licenses/>.
+**/
+
+package nfq
+
+import (
+	"context"
+
+	"github.com/florianl/go-nfqueue"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Queue pulls packets off a single NFQUEUE queue number and runs them
+// through a FilterFunc, round-tripping the verdict back to the kernel.
+type Queue struct {
+	nf     *nfqueue.Nfqueue
+	cancel context.CancelFunc
+}
+
+// Open binds to queueNum and starts delivering packets to fn until Close is
+// called. queueNum must match the number the caller installed an NFQUEUE
+// target for (see pkg/ebpfem's nftables wiring).
+func Open(queueNum uint16, fn FilterFunc) (*Queue, error) {
+	cfg := nfqueue.Config{
+		NfQueue:      queueNum,
+		MaxPacketLen: 0xffff,
+		MaxQueueLen:  1024,
+		Copymode:     nfqueue.NfQnlCopyPacket,
+	}
+
+	nf, err := nfqueue.Open(&cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open nfqueue %d", queueNum)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	q := &Queue{
+		nf:     nf,
+		cancel: cancel,
+	}
+
+	callback := func(a nfqueue.Attribute) int {
+		if a.PacketID == nil || a.Payload == nil {
+			return 0
+		}
+
+		// The nftables rule queues traffic on an inet table, so the payload
+		// can be either an IPv4 or an IPv6 datagram; the high nibble of the
+		// first byte of the IP header carries the version in both cases.
+		layerType := layers.LayerTypeIPv4
+		if payload := *a.Payload; len(payload) > 0 && payload[0]>>4 == 6 {
+			layerType = layers.LayerTypeIPv6
+		}
+		pkt := gopacket.NewPacket(*a.Payload, layerType, gopacket.Default)
+
+		verdict, mangled := fn(pkt)
+
+		switch verdict {
+		case Drop:
+			if err := nf.SetVerdict(*a.PacketID, nfqueue.NfDrop); err != nil {
+				log.Errorf("nfqueue %d: cannot set drop verdict: %v", queueNum, err)
+			}
+		case Mangle:
+			if err := nf.SetVerdictModPacket(*a.PacketID, nfqueue.NfAccept, mangled); err != nil {
+				log.Errorf("nfqueue %d: cannot set mangled verdict: %v", queueNum, err)
+			}
+		default:
+			if err := nf.SetVerdict(*a.PacketID, nfqueue.NfAccept); err != nil {
+				log.Errorf("nfqueue %d: cannot set accept verdict: %v", queueNum, err)
+			}
+		}
+
+		return 0
+	}
+
+	errFunc := func(e error) int {
+		log.Warnf("nfqueue %d: %v", queueNum, e)
+		return 0
+	}
+
+	if err := nf.RegisterWithErrorFunc(ctx, callback, errFunc); err != nil {
+		cancel()
+		_ = nf.Close()
+		return nil, errors.Wrapf(err, "cannot register callback for nfqueue %d", queueNum)
+	}
+
+	return q, nil
+}
+
+// Close stops delivery and releases the queue.
+func (q *Queue) Close() error {
+	q.cancel()
+	return errors.WithStack(q.nf.Close())
+}
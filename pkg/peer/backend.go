@@ -0,0 +1,81 @@
+/*
+* This file is part of Celestial (https://github.com/OpenFogStack/celestial).
+* Copyright (c) 2024 Tobias Pfandzelter, The OpenFogStack Team.
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, version 3.
+*
+* This program is distributed in the hope that it will be useful, but
+* WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+* General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program. If not, see <http://www.gnu.org/licenses/>.
+**/
+
+package peer
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// backend creates the OS-visible network interface backing a peer's wg
+// tunnel. Once created, address assignment, routing and wgctrl device
+// configuration are identical regardless of which backend produced the
+// interface, so PeeringService's other methods never need to know which is
+// in use.
+type backend interface {
+	// createLink brings up an interface named name and returns a closer
+	// that tears it down again.
+	createLink(name string) (io.Closer, error)
+}
+
+// kernelBackend configures the interface through the in-kernel WireGuard
+// driver via netlink.
+type kernelBackend struct{}
+
+type netlinkCloser struct {
+	link netlink.Link
+}
+
+func (c netlinkCloser) Close() error {
+	return netlink.LinkDel(c.link)
+}
+
+func (kernelBackend) createLink(name string) (io.Closer, error) {
+	link := &netlink.Wireguard{
+		LinkAttrs: netlink.LinkAttrs{Name: name},
+	}
+
+	if err := netlink.LinkAdd(link); err != nil {
+		return nil, err
+	}
+
+	return netlinkCloser{link}, nil
+}
+
+// autoBackend is the default backend: it prefers the in-kernel driver and
+// transparently falls back to the userspace wireguard-go implementation on
+// hosts where the kernel module is unavailable.
+type autoBackend struct{}
+
+func (autoBackend) createLink(name string) (io.Closer, error) {
+	closer, err := (kernelBackend{}).createLink(name)
+	if err == nil {
+		return closer, nil
+	}
+
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOTSUP) {
+		log.Warnf("kernel wireguard unavailable on this host (%s), falling back to userspace wireguard-go", err.Error())
+		return (userspaceBackend{}).createLink(name)
+	}
+
+	return nil, errors.Wrapf(err, "cannot create wireguard link %s", name)
+}
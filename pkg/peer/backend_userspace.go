@@ -0,0 +1,86 @@
+/*
+* This file is part of Celestial (https://github.com/OpenFogStack/celestial).
+* Copyright (c) 2024 Tobias Pfandzelter, The OpenFogStack Team.
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, version 3.
+*
+* This program is distributed in the hope that it will be useful, but
+* WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+* General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program. If not, see <http://www.gnu.org/licenses/>.
+**/
+
+package peer
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/ipc"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// userspaceBackend runs the wireguard-go userspace implementation against a
+// TUN device, for hosts that don't have the in-kernel WireGuard driver
+// (older kernels, containers without the wireguard module, non-amd64 CI).
+// wgctrl talks to it the same way it talks to the kernel driver, over the
+// UAPI unix socket it exposes, so Register/Route/InitPeering/Stop don't need
+// a separate code path for it.
+type userspaceBackend struct{}
+
+type userspaceCloser struct {
+	dev  *device.Device
+	uapi io.Closer
+}
+
+func (c userspaceCloser) Close() error {
+	_ = c.uapi.Close()
+	c.dev.Close()
+	return nil
+}
+
+func (userspaceBackend) createLink(name string) (io.Closer, error) {
+	tunDev, err := tun.CreateTUN(name, device.DefaultMTU)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create tun device %s", name)
+	}
+
+	logger := device.NewLogger(device.LogLevelError, fmt.Sprintf("(%s) ", name))
+	// StdNetBind (rather than the plain default UDP bind) opportunistically
+	// enables SO_TXTIME and UDP_SEGMENT on the underlay socket when the
+	// kernel supports them, so a GRO super-frame coming off tunDev is paced
+	// and segmented as one unit instead of packet by packet.
+	dev := device.NewDevice(tunDev, conn.NewStdNetBind(), logger)
+
+	uapi, err := ipc.UAPIListen(name)
+	if err != nil {
+		dev.Close()
+		return nil, errors.Wrapf(err, "cannot open uapi socket for %s", name)
+	}
+
+	go func() {
+		for {
+			c, err := uapi.Accept()
+			if err != nil {
+				return
+			}
+			go dev.IpcHandle(c)
+		}
+	}()
+
+	if err := dev.Up(); err != nil {
+		_ = uapi.Close()
+		dev.Close()
+		return nil, errors.Wrapf(err, "cannot bring up tun device %s", name)
+	}
+
+	return userspaceCloser{dev: dev, uapi: uapi}, nil
+}
@@ -0,0 +1,427 @@
+/*
+* This file is part of Celestial (https://github.com/OpenFogStack/celestial).
+* Copyright (c) 2024 Tobias Pfandzelter, The OpenFogStack Team.
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, version 3.
+*
+* This program is distributed in the hope that it will be useful, but
+* WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+* General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program. If not, see <http://www.gnu.org/licenses/>.
+**/
+
+package peer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ping/ping"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/OpenFogStack/celestial/pkg/netconfig"
+	"github.com/OpenFogStack/celestial/pkg/orchestrator"
+)
+
+type HostInfo struct {
+	Addr      string
+	PublicKey string
+}
+
+type peer struct {
+	directAddr  net.IP
+	wgAddr      net.IP
+	wgAddrV6    net.IP // 新增 IPv6 地址
+	allowedNets []*net.IPNet
+	sync.Mutex  // can't have two goroutines modifying this at the same time
+
+	port      uint16
+	publicKey wgtypes.Key
+	// microseconds
+	latency uint64
+}
+
+// PeeringService uses Wireguard to connect to other machines and route traffic to them.
+type PeeringService struct {
+	wgAddr      net.IP
+	wgAddrV6    net.IP // 新增 IPv6 地址
+	id          orchestrator.Host
+	mask        string
+	wgInterface string
+	keyPath     string
+	port        uint16
+
+	privateKey wgtypes.Key
+	publicKey  string
+
+	wg *wgctrl.Client
+
+	// backend creates the wg interface itself; it defaults to autoBackend,
+	// which prefers the in-kernel driver and falls back to userspace
+	// wireguard-go when that's unavailable. linkCloser tears down whatever
+	// interface backend.createLink returned, without Stop needing to know
+	// which backend made it.
+	backend    backend
+	linkCloser io.Closer
+
+	// cfg is shared with pkg/ebpfem so both packages synthesize 4in6
+	// mappings under the same operator-configured ULA prefix.
+	cfg *netconfig.Config
+
+	peers map[orchestrator.Host]*peer
+}
+
+// Option configures a PeeringService at construction time.
+type Option func(*PeeringService)
+
+// WithUserspaceBackend forces the userspace wireguard-go backend instead of
+// the default auto-detection, e.g. for hosts known not to have the kernel
+// WireGuard driver.
+func WithUserspaceBackend() Option {
+	return func(p *PeeringService) {
+		p.backend = userspaceBackend{}
+	}
+}
+
+// New creates a new PeeringService. cfg controls the IPv6 ULA prefix used
+// for wg addresses and 4in6 route synthesis; pass nil to use
+// netconfig.DefaultULAPrefix.
+func New(mask string, keypath string, wginterface string, port uint16, cfg *netconfig.Config, opts ...Option) (*PeeringService, error) {
+	if cfg == nil {
+		var err error
+		cfg, err = netconfig.New(netconfig.DefaultULAPrefix)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	// remove old stuff first
+	if link, err := netlink.LinkByName(wginterface); err == nil {
+		// errors are ok: the interface may not have existed before
+		_ = netlink.LinkDel(link)
+	}
+
+	log.Debugf("Removed old wg interface")
+
+	privatekey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	privateKeyFile, err := os.Create(keypath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer func(privateKeyFile *os.File) {
+		err := privateKeyFile.Close()
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}(privateKeyFile)
+
+	if _, err := privateKeyFile.WriteString(privatekey.String()); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	pubkey := privatekey.PublicKey().String()
+
+	log.Debugf("Private key: %s Public key %s", privatekey.String(), pubkey)
+
+	wg, err := wgctrl.New()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot open wgctrl client")
+	}
+
+	p := &PeeringService{
+		mask:        mask,
+		wgInterface: wginterface,
+		keyPath:     keypath,
+		port:        port,
+		privateKey:  privatekey,
+		publicKey:   pubkey,
+		wg:          wg,
+		backend:     autoBackend{},
+		cfg:         cfg,
+		peers:       make(map[orchestrator.Host]*peer),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p, nil
+}
+
+func (p *PeeringService) Register(host orchestrator.Host) (publickey string, listenaddr string, err error) {
+	wgaddr, err := p.getWGAddr(host, false) // IPv4 地址
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+
+	wgaddrV6, err := p.getWGAddr(host, true) // IPv6 地址
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+
+	p.wgAddr = wgaddr
+	p.wgAddrV6 = wgaddrV6
+	p.id = host
+
+	// ip link add [WGINTERFACE] type wireguard
+	closer, err := p.backend.createLink(p.wgInterface)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "cannot create wg interface %s", p.wgInterface)
+	}
+	p.linkCloser = closer
+
+	link, err := netlink.LinkByName(p.wgInterface)
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+
+	// ip addr add [OWN_WG_ADDRESS] dev [WGINTERFACE]
+	v4Ones, err := strconv.Atoi(strings.TrimPrefix(p.mask, "/"))
+	if err != nil {
+		return "", "", errors.Wrapf(err, "invalid mask %q", p.mask)
+	}
+
+	if err := netlink.AddrAdd(link, &netlink.Addr{IPNet: &net.IPNet{IP: p.wgAddr, Mask: net.CIDRMask(v4Ones, 32)}}); err != nil {
+		return "", "", errors.Wrapf(err, "cannot assign %s to %s", p.wgAddr, p.wgInterface)
+	}
+
+	// 添加 IPv6 地址
+	if err := netlink.AddrAdd(link, &netlink.Addr{IPNet: &net.IPNet{IP: p.wgAddrV6, Mask: net.CIDRMask(64, 128)}}); err != nil {
+		return "", "", errors.Wrapf(err, "cannot assign %s to %s", p.wgAddrV6, p.wgInterface)
+	}
+
+	// wg set [WGINTERFACE] private-key [PRIVATE_KEY_FILE] listen-port [WG_PORT]
+	listenPort := int(p.port)
+	if err := p.wg.ConfigureDevice(p.wgInterface, wgtypes.Config{
+		PrivateKey: &p.privateKey,
+		ListenPort: &listenPort,
+	}); err != nil {
+		return "", "", errors.Wrapf(err, "cannot configure %s", p.wgInterface)
+	}
+
+	// ip link set [WGINTERFACE] up
+	if err := netlink.LinkSetUp(link); err != nil {
+		return "", "", errors.Wrapf(err, "cannot bring up %s", p.wgInterface)
+	}
+
+	return p.publicKey, fmt.Sprintf(":%d", p.port), nil
+}
+
+func (p *PeeringService) GetHostID() (uint8, error) {
+	if p.wgAddr == nil {
+		return 0, errors.Errorf("not registered yet")
+	}
+
+	return uint8(p.id), nil
+}
+
+func (p *PeeringService) Route(network net.IPNet, host orchestrator.Host) error {
+	h, ok := p.peers[host]
+	if !ok {
+		return errors.Errorf("unknown host %d", host)
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	h.allowedNets = append(h.allowedNets, &network)
+
+	// 初始化 allowed-ips 列表，包括主 IPv4 和 IPv6 地址
+	allowedIPs := []net.IPNet{
+		{IP: h.wgAddr, Mask: net.CIDRMask(32, 32)},
+		{IP: h.wgAddrV6, Mask: net.CIDRMask(128, 128)},
+	}
+
+	// 遍历所有 allowedNets，添加 IPv4 和 IPv6 子网
+	for _, n := range h.allowedNets {
+		allowedIPs = append(allowedIPs, *n)
+
+		// 将 IPv4 子网转换为 IPv6 并添加到 allowedIPs
+		ipv6Subnet, err := p.cfg.Embed4Subnet(*n)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		allowedIPs = append(allowedIPs, ipv6Subnet)
+	}
+
+	// 配置 WireGuard allowed-ips
+	if err := p.wg.ConfigureDevice(p.wgInterface, wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{
+			{
+				PublicKey:         h.publicKey,
+				UpdateOnly:        true,
+				ReplaceAllowedIPs: true,
+				AllowedIPs:        allowedIPs,
+			},
+		},
+	}); err != nil {
+		return errors.Wrapf(err, "cannot update allowed-ips for peer %s", h.publicKey)
+	}
+
+	link, err := netlink.LinkByName(p.wgInterface)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// 添加新的 IPv4 路由（RouteReplace 同时处理了旧路由的替换）
+	if err := netlink.RouteReplace(&netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       &network,
+		Gw:        h.wgAddr,
+	}); err != nil {
+		return errors.Wrapf(err, "cannot add route to %s via %s", network.String(), h.wgAddr)
+	}
+
+	// 将当前 IPv4 子网转换为 IPv6 子网
+	ipv6Subnet, err := p.cfg.Embed4Subnet(network)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// 添加新的 IPv6 路由
+	if err := netlink.RouteReplace(&netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       &ipv6Subnet,
+		Gw:        h.wgAddrV6,
+	}); err != nil {
+		return errors.Wrapf(err, "cannot add route to %s via %s", ipv6Subnet.String(), h.wgAddrV6)
+	}
+
+	return nil
+}
+
+// getWGAddr returns the wg underlay address assigned to host: a fixed
+// 192.168.50.x address for IPv4, or its 4in6 mapping under p.cfg.ULAPrefix
+// for IPv6.
+func (p *PeeringService) getWGAddr(host orchestrator.Host, ipv6 bool) (net.IP, error) {
+	if host > 253 {
+		return nil, errors.Errorf("index %d is larger than allowed 253", host)
+	}
+
+	v4 := net.IPv4(0xC0, 0xA8, 0x32, byte(0x02+host))
+	if !ipv6 {
+		return v4, nil
+	}
+
+	return p.cfg.Embed4(v4)
+}
+
+func (p *PeeringService) InitPeering(remotes map[orchestrator.Host]HostInfo) error {
+	for remote, info := range remotes {
+		if remote == p.id {
+			continue
+		}
+
+		remoteWgAddr, err := p.getWGAddr(remote, false) // IPv4 地址
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		remoteWgAddrV6, err := p.getWGAddr(remote, true) // IPv6 地址
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		addr, port, err := net.SplitHostPort(info.Addr)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		portNum, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		publicKey, err := wgtypes.ParseKey(info.PublicKey)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		r := &peer{
+			directAddr:  net.ParseIP(addr),
+			wgAddr:      remoteWgAddr,
+			wgAddrV6:    remoteWgAddrV6,
+			allowedNets: []*net.IPNet{},
+			port:        uint16(portNum),
+			publicKey:   publicKey,
+		}
+
+		endpoint, err := net.ResolveUDPAddr("udp", net.JoinHostPort(r.directAddr.String(), port))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		// wg set [WGINTERFACE] peer [PEER_PUBLICKEY] allowed-ips [PEER_WG_ADDR]/32,[PEER_WG_ADDRV6]/128 endpoint [PEER_DIRECT_ADDR]:[WGPORT]
+		if err := p.wg.ConfigureDevice(p.wgInterface, wgtypes.Config{
+			Peers: []wgtypes.PeerConfig{
+				{
+					PublicKey: r.publicKey,
+					Endpoint:  endpoint,
+					AllowedIPs: []net.IPNet{
+						{IP: r.wgAddr, Mask: net.CIDRMask(32, 32)},
+						{IP: r.wgAddrV6, Mask: net.CIDRMask(128, 128)},
+					},
+				},
+			},
+		}); err != nil {
+			return errors.Wrapf(err, "cannot add peer %s", r.publicKey)
+		}
+
+		// test latency to this peer
+		pinger, err := ping.NewPinger(r.directAddr.String())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		pinger.SetPrivileged(true)
+		pinger.Count = 5
+		pinger.Timeout = 5 * time.Second
+
+		err = pinger.Run() // Blocks until finished.
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		stats := pinger.Statistics() // get send/receive/duplicate/rtt stats
+
+		// AvgRtt in Nanoseconds / 1e3 -> yields average rtt in microseconds
+		// average rtt / 2.0 -> yields one way latency
+		r.latency = uint64((stats.AvgRtt.Nanoseconds() / 1e3) / 2.0)
+
+		log.Debugf("Latency %dus", r.latency)
+		log.Infof("Determined a latency of %dus to host %s", r.latency, r.directAddr)
+
+		p.peers[remote] = r
+	}
+
+	return nil
+}
+
+func (p *PeeringService) Stop() error {
+	defer p.wg.Close()
+
+	if p.linkCloser == nil {
+		return errors.Errorf("not registered yet")
+	}
+
+	return errors.Wrapf(p.linkCloser.Close(), "cannot tear down %s", p.wgInterface)
+}